@@ -0,0 +1,68 @@
+package finn
+
+import (
+	"math/rand"
+	"time"
+)
+
+// BackoffStrategy computes how long to wait before the next retry of a job.
+// attempt is the attempt number that just failed (1-indexed), base is the
+// worker's configured RetryDelaySeconds() as a time.Duration.
+type BackoffStrategy interface {
+	NextDelay(attempt int, base time.Duration) time.Duration
+}
+
+// FixedBackoff always waits the same, base, delay between retries
+type FixedBackoff struct{}
+
+// NextDelay returns base unchanged
+func (FixedBackoff) NextDelay(attempt int, base time.Duration) time.Duration {
+	return base
+}
+
+// LinearBackoff waits base * attempt between retries
+type LinearBackoff struct{}
+
+// NextDelay returns base * attempt
+func (LinearBackoff) NextDelay(attempt int, base time.Duration) time.Duration {
+	return base * time.Duration(attempt)
+}
+
+// ExponentialBackoff waits base * 2^attempt between retries, up to Cap
+type ExponentialBackoff struct {
+	Cap time.Duration
+}
+
+// maxExponentialShift caps how far NextDelay will shift base left. A shift
+// that still overflows time.Duration (an int64) is caught below by shifting
+// the result back and comparing it with base, and is treated as exceeding Cap.
+const maxExponentialShift = 62
+
+// NextDelay returns min(Cap, base*2^attempt)
+func (self ExponentialBackoff) NextDelay(attempt int, base time.Duration) time.Duration {
+	if attempt > maxExponentialShift {
+		attempt = maxExponentialShift
+	}
+
+	delay := base << uint(attempt)
+	if delay>>uint(attempt) != base || (self.Cap > 0 && delay > self.Cap) {
+		return self.Cap
+	}
+
+	return delay
+}
+
+// ExponentialJitterBackoff is ExponentialBackoff with +/-50% jitter applied,
+// to avoid a thundering herd of retries all landing at the same moment when
+// a downstream service recovers.
+type ExponentialJitterBackoff struct {
+	Cap time.Duration
+}
+
+// NextDelay returns min(Cap, base*2^attempt) * rand[0.5,1.5]
+func (self ExponentialJitterBackoff) NextDelay(attempt int, base time.Duration) time.Duration {
+	exponential := ExponentialBackoff{Cap: self.Cap}.NextDelay(attempt, base)
+	jitter := 0.5 + rand.Float64()
+
+	return time.Duration(float64(exponential) * jitter)
+}
@@ -0,0 +1,64 @@
+package finn
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// SetDeadLetterTopic sets the topic that jobs are published to once they've
+// exhausted their retries, so operators can inspect and replay them
+func SetDeadLetterTopic(name string) error {
+	if runner.started {
+		return fmt.Errorf("Cannot set the dead-letter topic after Finn has started.")
+	}
+
+	runner.deadLetterTopic = name
+
+	return nil
+}
+
+// DeadLetter is the envelope a poisoned job is wrapped in before being
+// published to the dead-letter topic
+type DeadLetter struct {
+	Topic     string    `msgpack:"topic"`
+	Body      []byte    `msgpack:"body"`
+	Error     string    `msgpack:"error"`
+	Attempts  int       `msgpack:"attempts"`
+	FirstSeen time.Time `msgpack:"first_seen"`
+}
+
+// deadLetter publishes worker, along with failure metadata, to the
+// configured dead-letter topic. It's a no-op if none has been set.
+func (self *Runner) deadLetter(worker GenericWorker, lastErr error) {
+	if self.deadLetterTopic == "" {
+		return
+	}
+
+	body, err := worker.Pack()
+	if err != nil {
+		LogError(err)
+		return
+	}
+
+	letter := DeadLetter{
+		Topic:     worker.TopicName(),
+		Body:      body,
+		Attempts:  worker.Attempts(),
+		FirstSeen: worker.FirstSeen(),
+	}
+	if lastErr != nil {
+		letter.Error = lastErr.Error()
+	}
+
+	packed, err := msgpack.Marshal(letter)
+	if err != nil {
+		LogError(err)
+		return
+	}
+
+	if err := self.queue.Publish(self.deadLetterTopic, packed); err != nil {
+		LogError(err)
+	}
+}
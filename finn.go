@@ -4,21 +4,19 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"reflect"
 	"runtime"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 /* TODO
-- Move Unpack & Pack into worker, this will allow the worker to define its own message format.
-	- Put default Unpack & Pack (msgpack for now) into BaseWorker.
 - Write tests
 - Code review
 - Clean up Start() and Runner{} (and rest of code?)
-- Create a Kafka queue implementation, based on sarama
-	- Set consumer group via config
-	- Set partition via config
 */
 
 var runner = Runner{}
@@ -33,6 +31,10 @@ func AddWorker(worker GenericWorker) error {
 		runner.workers = make(map[string]GenericWorker)
 	}
 
+	if setter, ok := worker.(selfSetter); ok {
+		setter.setSelf(worker)
+	}
+
 	runner.workers[worker.TopicName()] = worker
 
 	return nil
@@ -50,6 +52,30 @@ func SetQueue(userQueue GenericQueue, userConfig QueueConfig) error {
 	return nil
 }
 
+// SetShutdownTimeout sets how long Listen will wait for in-flight workers to
+// finish once a shutdown signal is received before forcing the queue closed
+func SetShutdownTimeout(timeout time.Duration) error {
+	if runner.started {
+		return fmt.Errorf("Cannot set the shutdown timeout after Finn has started.")
+	}
+
+	runner.shutdownTimeout = timeout
+
+	return nil
+}
+
+// OnShutdown registers a hook that runs once a shutdown signal has been
+// received, before Finn waits for in-flight workers to drain
+func OnShutdown(hook func()) {
+	runner.OnShutdown(hook)
+}
+
+// OnTerminate registers a hook that runs if the shutdown timeout is reached
+// before all in-flight workers finished
+func OnTerminate(hook func()) {
+	runner.OnTerminate(hook)
+}
+
 // Listen boots Finn up and begins listening for work on the queue
 func Listen() {
 	LogInfoColour("Starting Finn up.")
@@ -68,13 +94,18 @@ func Listen() {
 	// Shut things down properly
 	defer runner.Close()
 
+	// Start the admin HTTP server, if one was configured
+	if runner.http != nil {
+		runner.http.Start()
+	}
+
 	// Set up signal channel, safely shutdown on detection of a signal
 	signalChannel := make(chan os.Signal, 1)
 	signal.Notify(signalChannel, syscall.SIGTERM, syscall.SIGKILL, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT)
 
 	// Connect to topics for workers to listen on, then
-	// get a channel of messages from each topic
-	streams := make(map[string]<-chan []byte)
+	// get a channel of acks from each topic
+	streams := make(map[string]<-chan AckableMessage)
 	for _, worker := range runner.workers {
 
 		topic, err := runner.queue.NewTopic(worker.TopicName())
@@ -83,7 +114,7 @@ func Listen() {
 			return
 		}
 
-		stream, err := topic.Stream()
+		stream, err := topicAcks(topic)
 		if err != nil {
 			LogError(err)
 			return
@@ -108,12 +139,19 @@ MainLoop:
 			break MainLoop
 		case job, ok := <-jobs:
 			if ok {
-				worker, err := Unpack(job.body, runner.workers[job.topic])
-				if err != nil {
+				worker := newWorkerInstance(runner.workers[job.topic])
+				if err := worker.Unpack(job.body); err != nil {
 					LogError(err)
 				} else {
+					atomic.AddInt64(&runner.statsFor(job.topic).Received, 1)
 					waitGroup.Add(1)
-					runner.Run(worker, waitGroup)
+					id := runner.trackInFlight(job.nack)
+					runner.Run(worker, waitGroup, func() {
+						runner.untrackInFlight(id)
+						if job.ack != nil {
+							job.ack()
+						}
+					})
 				}
 			} else {
 				LogError(fmt.Errorf("Problem with worker delivery\n"))
@@ -121,18 +159,85 @@ MainLoop:
 		}
 	}
 
-	// Waiting for all goroutines/workers to finish processing before shutdown
-	waitGroup.Wait()
+	// Run shutdown hooks before we start waiting on in-flight workers
+	runner.runHooks(runner.onShutdown)
+
+	// Wait for in-flight workers to finish, for at most the shutdown timeout
+	// if one has been set, otherwise for as long as they take.
+	drained := make(chan struct{})
+	go func() {
+		waitGroup.Wait()
+		close(drained)
+	}()
+
+	if runner.shutdownTimeout > 0 {
+		select {
+		case <-drained:
+		case <-time.After(runner.shutdownTimeout):
+			LogError(fmt.Errorf("Shutdown timeout (%s) reached, nacking jobs still in flight so they're redelivered.", runner.shutdownTimeout))
+			runner.nackInFlight()
+			runner.runHooks(runner.onTerminate)
+		}
+	} else {
+		<-drained
+	}
+}
+
+// newWorkerInstance returns a fresh zero-value worker of the same concrete
+// type as registered, with setSelf wired up if it supports it. AddWorker
+// registers a single shared instance per topic, so unpacking a delivery
+// directly into it would race: Kafka in particular can deliver several
+// messages for the same topic concurrently (one per partition), each
+// wanting to Unpack its own body and IncreaseAttempts independently.
+func newWorkerInstance(registered GenericWorker) GenericWorker {
+	instance := reflect.New(reflect.TypeOf(registered).Elem()).Interface().(GenericWorker)
+	if setter, ok := instance.(selfSetter); ok {
+		setter.setSelf(instance)
+	}
+
+	return instance
 }
 
-// Job represents the packed job + the topic it came in on
+// Job represents the packed job + the topic it came in on. ack, when set,
+// must be called once the job reaches a terminal state (success, a failure
+// that isn't retried, or retries exhausted) so the originating topic can
+// consider the message delivered.
+// nack, when set, requeues the job instead; Finn calls it for jobs still
+// running when the shutdown timeout is reached. ack and nack are mutually
+// exclusive, whichever fires first wins.
 type Job struct {
 	topic string
 	body  []byte
+	ack   func()
+	nack  func()
+}
+
+// topicAcks returns a channel of AckableMessage for topic, whether or not it
+// natively supports acking: topics that don't implement AckableTopic get a
+// no-op Ack wired in, so multiplex doesn't need to care which kind it has.
+func topicAcks(topic GenericTopic) (<-chan AckableMessage, error) {
+	if ackable, ok := topic.(AckableTopic); ok {
+		return ackable.StreamAcks()
+	}
+
+	stream, err := topic.Stream()
+	if err != nil {
+		return nil, err
+	}
+
+	acks := make(chan AckableMessage)
+	go func() {
+		for body := range stream {
+			acks <- AckableMessage{Body: body, Ack: func() error { return nil }}
+		}
+		close(acks)
+	}()
+
+	return acks, nil
 }
 
 // multiplex takes multiple input channels and routes them to a single output channel
-func multiplex(streams map[string]<-chan []byte) <-chan Job {
+func multiplex(streams map[string]<-chan AckableMessage) <-chan Job {
 	jobs := make(chan Job)
 
 	// Range over all input channels
@@ -140,10 +245,34 @@ func multiplex(streams map[string]<-chan []byte) <-chan Job {
 		// Re-declaration is necessary, otherwise goroutines will all share the same variables
 		topic := topic
 		stream := stream
+
 		go func() {
 			// Range over messages from input channel, outputting them as a job
 			for message := range stream {
-				jobs <- Job{body: message, topic: topic}
+				message := message
+				var once sync.Once
+
+				jobs <- Job{
+					body:  message.Body,
+					topic: topic,
+					ack: func() {
+						once.Do(func() {
+							if err := message.Ack(); err != nil {
+								LogError(err)
+							}
+						})
+					},
+					nack: func() {
+						once.Do(func() {
+							if message.Nack == nil {
+								return
+							}
+							if err := message.Nack(); err != nil {
+								LogError(err)
+							}
+						})
+					},
+				}
 			}
 		}()
 	}
@@ -157,6 +286,68 @@ type Runner struct {
 	config  QueueConfig
 	workers map[string]GenericWorker
 	started bool
+
+	shutdownTimeout time.Duration
+	onShutdown      []func()
+	onTerminate     []func()
+
+	http  *httpServer
+	stats map[string]*WorkerStats
+
+	deadLetterTopic string
+
+	// inFlight tracks the nack function for every job currently running, keyed
+	// by an id handed out by trackInFlight, so jobs still running when the
+	// shutdown timeout is reached can be nacked and redelivered.
+	inFlight  sync.Map
+	nextJobID int64
+}
+
+// OnShutdown registers a hook that runs once a shutdown signal has been
+// received, before Finn waits for in-flight workers to drain
+func (self *Runner) OnShutdown(hook func()) {
+	self.onShutdown = append(self.onShutdown, hook)
+}
+
+// OnTerminate registers a hook that runs if the shutdown timeout is reached
+// before all in-flight workers finished
+func (self *Runner) OnTerminate(hook func()) {
+	self.onTerminate = append(self.onTerminate, hook)
+}
+
+// trackInFlight records nack as the way to redeliver a job that's still
+// running when Finn shuts down, returning an id to clear it by once the job
+// reaches a terminal state. nack may be nil if the job's topic doesn't
+// support it.
+func (self *Runner) trackInFlight(nack func()) int64 {
+	id := atomic.AddInt64(&self.nextJobID, 1)
+	self.inFlight.Store(id, nack)
+	return id
+}
+
+// untrackInFlight clears the nack function tracked for a job that has
+// reached a terminal state (it was acked, or its retries were exhausted)
+func (self *Runner) untrackInFlight(id int64) {
+	self.inFlight.Delete(id)
+}
+
+// nackInFlight nacks every job still tracked as running, so queues that
+// support it redeliver them, then forgets about them
+func (self *Runner) nackInFlight() {
+	self.inFlight.Range(func(key, value interface{}) bool {
+		if nack, ok := value.(func()); ok && nack != nil {
+			nack()
+		}
+		self.inFlight.Delete(key)
+		return true
+	})
+}
+
+// runHooks runs each hook in turn, in the goroutine that calls it
+func (self *Runner) runHooks(hooks []func()) {
+	for _, hook := range hooks {
+		hook()
+	}
 }
 
 // Initialize sets up the worker runner
@@ -179,11 +370,19 @@ func (self *Runner) Initialize() error {
 		return err
 	}
 
+	// Pre-populate stats so the admin server never races on map creation
+	for topic := range self.workers {
+		self.statsFor(topic)
+	}
+
 	return nil
 }
 
-// Run handles the run and retry logic for a single job
-func (self *Runner) Run(worker GenericWorker, waitGroup *sync.WaitGroup) {
+// Run handles the run and retry logic for a single job. ack, if non-nil, is
+// called once the job succeeds, fails without being retried (Run() returned
+// retry=false, or it panicked under PanicPolicyFail), or its retries are
+// exhausted.
+func (self *Runner) Run(worker GenericWorker, waitGroup *sync.WaitGroup, ack func()) {
 	// No work to do
 	if worker == nil {
 		return
@@ -191,11 +390,43 @@ func (self *Runner) Run(worker GenericWorker, waitGroup *sync.WaitGroup) {
 
 	// Do the retrying logic asynchronously
 	go func() {
-		success := make(chan bool)
+		result := make(chan error, 1)
+		stats := self.statsFor(worker.TopicName())
+		running := false
+		var start time.Time
 
 		// Run the worker
-		// TODO look into panics
 		func() {
+			// A panicking worker must not take the rest of Finn down with it.
+			// Recover, turn it into an error, and feed it back through the
+			// normal retry path (unless the worker opts out via PanicPolicy).
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+
+				if running {
+					atomic.AddInt64(&stats.Running, -1)
+					atomic.AddInt64(&stats.durationNs, int64(time.Since(start)))
+				}
+				atomic.AddInt64(&stats.Failed, 1)
+
+				err := fmt.Errorf("%s: recovered from panic: %v\n%s", worker.Name(), r, debug.Stack())
+				LogError(err)
+
+				if worker.PanicPolicy() == PanicPolicyFail {
+					waitGroup.Done()
+					if ack != nil {
+						ack()
+					}
+					result <- nil
+					return
+				}
+
+				result <- err
+			}()
+
 			if duration := worker.RunDelay(); duration.Seconds() > 0 {
 				LogInfo(fmt.Sprintf("%s: Delaying job for %s", worker.Name(), duration.String()))
 				time.Sleep(duration)
@@ -203,47 +434,73 @@ func (self *Runner) Run(worker GenericWorker, waitGroup *sync.WaitGroup) {
 
 			worker.IncreaseAttempts()
 
+			atomic.AddInt64(&stats.Running, 1)
+			running = true
+			start = time.Now()
+
 			LogInfo(fmt.Sprintf("%s: Running job [%d of %d]", worker.Name(), worker.Attempts(), worker.MaxAttempts()))
 			err, retry := worker.Run()
+
+			atomic.AddInt64(&stats.Running, -1)
+			atomic.AddInt64(&stats.durationNs, int64(time.Since(start)))
+			if err != nil {
+				atomic.AddInt64(&stats.Failed, 1)
+			} else {
+				atomic.AddInt64(&stats.Succeeded, 1)
+			}
+
 			if err != nil && retry {
-				success <- false
+				result <- err
 				return
 			} else if err != nil {
 				LogError(fmt.Errorf("%s - %s\n", worker.Name(), err.Error()))
 			}
 
 			waitGroup.Done()
-			success <- true
+			if ack != nil {
+				ack()
+			}
+			result <- nil
 		}()
 
-		// Waiting for the result of the worker
-		var wasSuccess bool
-		wasSuccess = <-success
-
 		// Retry the worker if it failed
-		if !wasSuccess {
+		if err := <-result; err != nil {
 			func() {
-				self.Retry(worker, waitGroup)
+				self.Retry(worker, waitGroup, ack, err)
 			}()
 		}
 	}()
 }
 
-// Retry handles the logic for retrying a job
-func (self *Runner) Retry(worker GenericWorker, waitGroup *sync.WaitGroup) {
+// Retry handles the logic for retrying a job. lastErr is the error returned
+// by the most recent Run(), used as dead-letter failure metadata if retries
+// end up exhausted.
+func (self *Runner) Retry(worker GenericWorker, waitGroup *sync.WaitGroup, ack func(), lastErr error) {
 	if worker.Attempts() >= worker.MaxAttempts() {
 		LogError(fmt.Errorf("%s - Max attempts (%d) for job reached, failed to process job.", worker.Name(), worker.Attempts()))
 		waitGroup.Done()
+		self.deadLetter(worker, lastErr)
+		if ack != nil {
+			ack()
+		}
 		return
 	}
 
-	worker.SetStartStamp(worker.NextStartStamp(worker.RetryDelaySeconds()))
+	base := time.Duration(worker.RetryDelaySeconds()) * time.Second
+	delay := worker.Backoff().NextDelay(worker.Attempts(), base)
+	worker.SetStartStamp(worker.NextStartStamp(delay))
+	atomic.AddInt64(&self.statsFor(worker.TopicName()).Retried, 1)
 
 	LogError(fmt.Errorf("%s: Retrying event [%d of %d]", worker.Name(), worker.Attempts()+1, worker.MaxAttempts()))
-	self.Run(worker, waitGroup)
+	self.Run(worker, waitGroup, ack)
 }
 
-// Close shuts down the Runner and underlying queue
+// Close nacks any jobs still tracked as in flight, so they're redelivered
+// rather than lost, then tears down the underlying queue
 func (self *Runner) Close() {
-	self.queue.Close()
+	self.nackInFlight()
+
+	if err := self.queue.Close(); err != nil {
+		LogError(err)
+	}
 }
@@ -0,0 +1,130 @@
+package finn
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// panicWorker panics the first time it's run, then succeeds
+type panicWorker struct {
+	BaseWorker
+	runs int64
+}
+
+func (self *panicWorker) TopicName() string      { return "panic-worker" }
+func (self *panicWorker) Name() string           { return "panicWorker" }
+func (self *panicWorker) RetryDelaySeconds() int { return 0 }
+
+func (self *panicWorker) Run() (error, bool) {
+	if atomic.AddInt64(&self.runs, 1) == 1 {
+		panic("boom")
+	}
+
+	return nil, false
+}
+
+// TestRunRecoversFromPanic asserts that a worker panicking inside Run() is
+// recovered and retried rather than taking the runner down with it, and that
+// the Running gauge settles back at 0 rather than going negative.
+func TestRunRecoversFromPanic(t *testing.T) {
+	runner := &Runner{}
+	worker := &panicWorker{}
+
+	waitGroup := new(sync.WaitGroup)
+	waitGroup.Add(1)
+	runner.Run(worker, waitGroup, nil)
+
+	done := make(chan struct{})
+	go func() {
+		waitGroup.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("worker never completed after panicking and retrying")
+	}
+
+	if runs := atomic.LoadInt64(&worker.runs); runs != 2 {
+		t.Fatalf("expected worker to run twice (panic then retry), ran %d times", runs)
+	}
+
+	stats := runner.statsFor(worker.TopicName())
+	if running := atomic.LoadInt64(&stats.Running); running != 0 {
+		t.Fatalf("expected Running to settle back at 0, got %d", running)
+	}
+	if failed := atomic.LoadInt64(&stats.Failed); failed != 1 {
+		t.Fatalf("expected exactly 1 recorded failure from the panic, got %d", failed)
+	}
+	if avg := stats.AverageDuration(); avg <= 0 {
+		t.Fatalf("expected the panicking attempt's run time to count towards AverageDuration, got %s", avg)
+	}
+}
+
+// fixedTestBackoff always waits delay, regardless of attempt or base
+type fixedTestBackoff struct {
+	delay time.Duration
+}
+
+func (self fixedTestBackoff) NextDelay(attempt int, base time.Duration) time.Duration {
+	return self.delay
+}
+
+// delayedRetryWorker fails the first time it's run, then succeeds, backing
+// off a fixed delay between the two
+type delayedRetryWorker struct {
+	BaseWorker
+	runs int64
+}
+
+func (self *delayedRetryWorker) TopicName() string     { return "delayed-retry-worker" }
+func (self *delayedRetryWorker) Name() string           { return "delayedRetryWorker" }
+func (self *delayedRetryWorker) RetryDelaySeconds() int { return 0 }
+func (self *delayedRetryWorker) Backoff() BackoffStrategy {
+	return fixedTestBackoff{delay: 150 * time.Millisecond}
+}
+
+func (self *delayedRetryWorker) Run() (error, bool) {
+	if atomic.AddInt64(&self.runs, 1) == 1 {
+		return errors.New("boom"), true
+	}
+
+	return nil, false
+}
+
+// TestRetryWaitsForBackoff asserts that the delay Backoff().NextDelay()
+// computes is actually observed before the retry runs, rather than firing
+// immediately.
+func TestRetryWaitsForBackoff(t *testing.T) {
+	runner := &Runner{}
+	worker := &delayedRetryWorker{}
+
+	waitGroup := new(sync.WaitGroup)
+	waitGroup.Add(1)
+	start := time.Now()
+	runner.Run(worker, waitGroup, nil)
+
+	done := make(chan struct{})
+	go func() {
+		waitGroup.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("worker never completed after failing and retrying")
+	}
+	elapsed := time.Since(start)
+
+	if runs := atomic.LoadInt64(&worker.runs); runs != 2 {
+		t.Fatalf("expected worker to run twice (fail then retry), ran %d times", runs)
+	}
+	if elapsed < 150*time.Millisecond {
+		t.Fatalf("expected the retry to wait out the 150ms backoff, only took %s", elapsed)
+	}
+}
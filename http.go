@@ -0,0 +1,114 @@
+package finn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"sync/atomic"
+)
+
+// HealthChecker can optionally be implemented by a GenericQueue to let
+// /health reflect the state of the underlying connection
+type HealthChecker interface {
+	Healthy() bool
+}
+
+// HTTPOption configures the admin server started by SetHTTP
+type HTTPOption func(*httpServer)
+
+// WithFailureThreshold marks /health unhealthy once a worker's failure rate
+// (failed / (succeeded + failed)) rises above threshold. Defaults to 0.5.
+func WithFailureThreshold(threshold float64) HTTPOption {
+	return func(self *httpServer) {
+		self.failureThreshold = threshold
+	}
+}
+
+// httpServer holds the configuration for Finn's optional admin server
+type httpServer struct {
+	addr             string
+	failureThreshold float64
+}
+
+// SetHTTP starts an admin HTTP server on addr once Finn is listening,
+// exposing /stats, /health and /debug/pprof/*
+func SetHTTP(addr string, opts ...HTTPOption) error {
+	if runner.started {
+		return fmt.Errorf("Cannot set the HTTP server after Finn has started.")
+	}
+
+	server := &httpServer{addr: addr, failureThreshold: 0.5}
+	for _, opt := range opts {
+		opt(server)
+	}
+
+	runner.http = server
+
+	return nil
+}
+
+// Start boots the admin server in the background
+func (self *httpServer) Start() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", self.handleStats)
+	mux.HandleFunc("/health", self.handleHealth)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	LogInfo(fmt.Sprintf("Admin HTTP server listening on %s", self.addr))
+
+	go func() {
+		if err := http.ListenAndServe(self.addr, mux); err != nil {
+			LogError(err)
+		}
+	}()
+}
+
+type workerStatsResponse struct {
+	Topic             string  `json:"topic"`
+	Received          int64   `json:"received"`
+	Succeeded         int64   `json:"succeeded"`
+	Failed            int64   `json:"failed"`
+	Retried           int64   `json:"retried"`
+	Running           int64   `json:"running"`
+	AverageDurationMs float64 `json:"average_duration_ms"`
+}
+
+func (self *httpServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	response := make([]workerStatsResponse, 0, len(runner.stats))
+	for topic, stats := range runner.stats {
+		response = append(response, workerStatsResponse{
+			Topic:             topic,
+			Received:          atomic.LoadInt64(&stats.Received),
+			Succeeded:         atomic.LoadInt64(&stats.Succeeded),
+			Failed:            atomic.LoadInt64(&stats.Failed),
+			Retried:           atomic.LoadInt64(&stats.Retried),
+			Running:           atomic.LoadInt64(&stats.Running),
+			AverageDurationMs: float64(stats.AverageDuration().Microseconds()) / 1000,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (self *httpServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if checker, ok := runner.queue.(HealthChecker); ok && !checker.Healthy() {
+		http.Error(w, "queue connection is down", http.StatusServiceUnavailable)
+		return
+	}
+
+	for topic, stats := range runner.stats {
+		if stats.FailureRate() > self.failureThreshold {
+			http.Error(w, fmt.Sprintf("worker %s failure rate exceeds threshold", topic), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
@@ -0,0 +1,249 @@
+package finn
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// errNacked is returned from kafkaClaimHandler.ConsumeClaim when a message is
+// nacked, forcing the claim to end (and the consumer group to rejoin)
+// without marking the message's offset, so it gets redelivered.
+var errNacked = errors.New("finn: message nacked, rejoining to force redelivery")
+
+// KafkaQueue is a GenericQueue backed by Kafka. Multiple Finn instances
+// sharing the same QueueConfig.ConsumerGroup will load-balance partitions
+// between them.
+type KafkaQueue struct {
+	config       KafkaConfig
+	saramaConfig *sarama.Config
+	producer     sarama.SyncProducer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	clients []sarama.ConsumerGroup
+	closed  bool
+}
+
+// KafkaConfig configures the Kafka consumer group used by KafkaQueue.
+type KafkaConfig struct {
+	// Brokers is the list of seed Kafka brokers, e.g. []string{"localhost:9092"}
+	Brokers []string
+
+	// ConsumerGroup is the group id used when subscribing to topics, this is
+	// what allows multiple Finn instances to load-balance partitions between them
+	ConsumerGroup string
+
+	// InitialOffset is where to start consuming from when a group has no
+	// committed offset yet, sarama.OffsetOldest or sarama.OffsetNewest
+	InitialOffset int64
+}
+
+// Initialize connects KafkaQueue to the configured brokers
+func (self *KafkaQueue) Initialize(config QueueConfig) error {
+	self.config = KafkaConfig{
+		Brokers:       config.Brokers,
+		ConsumerGroup: config.ConsumerGroup,
+		InitialOffset: config.InitialOffset,
+	}
+
+	if self.config.InitialOffset == 0 {
+		self.config.InitialOffset = sarama.OffsetNewest
+	}
+
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Consumer.Offsets.Initial = self.config.InitialOffset
+	saramaConfig.Consumer.Return.Errors = true
+	self.saramaConfig = saramaConfig
+
+	self.ctx, self.cancel = context.WithCancel(context.Background())
+
+	producerConfig := sarama.NewConfig()
+	producerConfig.Producer.Return.Successes = true
+
+	producer, err := sarama.NewSyncProducer(self.config.Brokers, producerConfig)
+	if err != nil {
+		return err
+	}
+	self.producer = producer
+
+	return nil
+}
+
+// Publish sends body to the given Kafka topic
+func (self *KafkaQueue) Publish(topic string, body []byte) error {
+	_, _, err := self.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(body),
+	})
+
+	return err
+}
+
+// NewTopic subscribes to a topic under the configured consumer group. Each
+// topic gets its own sarama.ConsumerGroup client rather than sharing one
+// across topics: sarama expects a single caller driving Consume (and reading
+// Errors()) per client, so sharing one between concurrently-running topics
+// would mis-subscribe both and split the error stream between them.
+func (self *KafkaQueue) NewTopic(name string) (GenericTopic, error) {
+	client, err := sarama.NewConsumerGroup(self.config.Brokers, self.config.ConsumerGroup, self.saramaConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	self.mu.Lock()
+	self.clients = append(self.clients, client)
+	self.mu.Unlock()
+
+	return &KafkaTopic{
+		name:   name,
+		client: client,
+		ctx:    self.ctx,
+	}, nil
+}
+
+// Healthy reports whether the queue has been closed. Sarama doesn't expose a
+// cheap way to probe live broker connectivity from a ConsumerGroup or
+// SyncProducer, so this reflects our own shutdown state rather than the
+// health of the TCP connections underneath it.
+func (self *KafkaQueue) Healthy() bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return !self.closed
+}
+
+// Close cancels every topic's consume loop, then shuts down the consumer
+// group clients and producer
+func (self *KafkaQueue) Close() error {
+	self.cancel()
+
+	self.mu.Lock()
+	self.closed = true
+	clients := self.clients
+	self.mu.Unlock()
+
+	if self.producer != nil {
+		self.producer.Close()
+	}
+
+	var firstErr error
+	for _, client := range clients {
+		if err := client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// KafkaTopic streams messages for a single Kafka topic, delivering them
+// with at-least-once semantics: the consumer offset for a message is only
+// committed once its AckableMessage.Ack is called, which Finn does after the
+// worker's Run() succeeds or its retries are exhausted.
+type KafkaTopic struct {
+	name   string
+	client sarama.ConsumerGroup
+	ctx    context.Context
+}
+
+// Stream subscribes to the topic and delivers message bodies as they arrive,
+// acking each one as soon as it's delivered. Prefer StreamAcks when the
+// caller can control when a message is actually done being processed.
+func (self *KafkaTopic) Stream() (<-chan []byte, error) {
+	acks, err := self.StreamAcks()
+	if err != nil {
+		return nil, err
+	}
+
+	stream := make(chan []byte)
+	go func() {
+		for msg := range acks {
+			stream <- msg.Body
+			if err := msg.Ack(); err != nil {
+				LogError(err)
+			}
+		}
+		close(stream)
+	}()
+
+	return stream, nil
+}
+
+// StreamAcks subscribes to the topic and delivers each message paired with
+// the function that commits its offset. Each message's Ack is correlated to
+// that exact delivery via the claim handler's own local state, never by the
+// message's content, so two in-flight messages with identical bodies on
+// different partitions can never be cross-wired.
+func (self *KafkaTopic) StreamAcks() (<-chan AckableMessage, error) {
+	acks := make(chan AckableMessage)
+
+	go func() {
+		defer close(acks)
+
+		for {
+			if self.ctx.Err() != nil {
+				return
+			}
+
+			err := self.client.Consume(self.ctx, []string{self.name}, &kafkaClaimHandler{acks: acks})
+			if self.ctx.Err() != nil {
+				// Close() cancelled us (or the client was closed directly),
+				// this isn't a real consume error, just our cue to exit
+				// rather than spin back into Consume.
+				return
+			}
+			if err != nil && err != errNacked {
+				LogError(err)
+			}
+		}
+	}()
+
+	go func() {
+		for err := range self.client.Errors() {
+			LogError(err)
+		}
+	}()
+
+	return acks, nil
+}
+
+// kafkaClaimHandler implements sarama.ConsumerGroupHandler, forwarding
+// messages onto the topic's acks channel and blocking on each one, via its
+// own local done channel, until it is acked before moving on to the next
+type kafkaClaimHandler struct {
+	acks chan<- AckableMessage
+}
+
+func (self *kafkaClaimHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (self *kafkaClaimHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (self *kafkaClaimHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for message := range claim.Messages() {
+		message := message
+		done := make(chan error, 1)
+
+		self.acks <- AckableMessage{
+			Body: message.Value,
+			Ack: func() error {
+				done <- nil
+				return nil
+			},
+			Nack: func() error {
+				done <- errNacked
+				return nil
+			},
+		}
+
+		if err := <-done; err != nil {
+			return err
+		}
+		session.MarkMessage(message, "")
+	}
+
+	return nil
+}
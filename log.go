@@ -0,0 +1,20 @@
+package finn
+
+import (
+	"log"
+)
+
+// LogInfo logs an informational message
+func LogInfo(message string) {
+	log.Printf("[Finn] %s\n", message)
+}
+
+// LogInfoColour logs an informational message in colour, used for startup/shutdown banners
+func LogInfoColour(message string) {
+	log.Printf("\033[36m[Finn] %s\033[0m\n", message)
+}
+
+// LogError logs an error
+func LogError(err error) {
+	log.Printf("\033[31m[Finn] %s\033[0m\n", err.Error())
+}
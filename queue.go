@@ -0,0 +1,78 @@
+package finn
+
+// GenericQueue is the interface a queue backend must satisfy to be used by Finn
+type GenericQueue interface {
+	// Initialize connects to the queue using the given config
+	Initialize(config QueueConfig) error
+
+	// NewTopic subscribes to (or declares) a topic and returns a handle to it
+	NewTopic(name string) (GenericTopic, error)
+
+	// Publish sends a packed job body to the given topic
+	Publish(topic string, body []byte) error
+
+	// Close tears down the connection to the queue
+	Close() error
+}
+
+// Enqueue packs worker using its own Pack implementation and publishes it to
+// its TopicName() on the queue Finn was started with
+func Enqueue(worker GenericWorker) error {
+	if setter, ok := worker.(selfSetter); ok {
+		setter.setSelf(worker)
+	}
+
+	body, err := worker.Pack()
+	if err != nil {
+		return err
+	}
+
+	return runner.queue.Publish(worker.TopicName(), body)
+}
+
+// GenericTopic is a single topic/queue that messages can be streamed from
+type GenericTopic interface {
+	// Stream returns a channel of raw message bodies delivered on this topic
+	Stream() (<-chan []byte, error)
+}
+
+// AckableMessage pairs a delivered message body with the functions that must
+// be called once it has reached a terminal state: Ack once the job
+// succeeds or its retries are exhausted, Nack if it's still in flight when
+// Finn is shutting down and needs it redelivered. Both must be correlated to
+// that exact delivery (e.g. via a partition/offset or a per-delivery
+// closure), never to the message's body, since identical bodies can be in
+// flight concurrently. Nack may be nil if the backend has no way to force
+// redelivery (the message is then simply left unacked).
+type AckableMessage struct {
+	Body []byte
+	Ack  func() error
+	Nack func() error
+}
+
+// AckableTopic is implemented by topics that need an explicit acknowledgement
+// once a message has been fully handled (successfully or not) before they can
+// consider it delivered. Finn calls Ack once a job reaches a terminal state.
+type AckableTopic interface {
+	GenericTopic
+
+	// StreamAcks behaves like Stream, but pairs each message with the
+	// function that acknowledges it.
+	StreamAcks() (<-chan AckableMessage, error)
+}
+
+// QueueConfig holds the configuration needed to connect to a queue backend.
+// Not every field is used by every backend.
+type QueueConfig struct {
+	// Url is the connection string for the queue (e.g. an AMQP url)
+	Url string
+
+	// ExchangeName and ExchangeType are used by RabbitMQ
+	ExchangeName string
+	ExchangeType string
+
+	// Brokers, ConsumerGroup and InitialOffset are used by Kafka
+	Brokers       []string
+	ConsumerGroup string
+	InitialOffset int64
+}
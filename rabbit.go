@@ -0,0 +1,132 @@
+package finn
+
+import (
+	"github.com/streadway/amqp"
+)
+
+// RabbitQueue is a GenericQueue backed by RabbitMQ
+type RabbitQueue struct {
+	config  QueueConfig
+	conn    *amqp.Connection
+	channel *amqp.Channel
+}
+
+// Initialize connects to RabbitMQ and opens a channel
+func (self *RabbitQueue) Initialize(config QueueConfig) error {
+	self.config = config
+
+	conn, err := amqp.Dial(config.Url)
+	if err != nil {
+		return err
+	}
+	self.conn = conn
+
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	self.channel = channel
+
+	return nil
+}
+
+// NewTopic declares a queue and binds it to the configured exchange
+func (self *RabbitQueue) NewTopic(name string) (GenericTopic, error) {
+	queue, err := self.channel.QueueDeclare(name, true, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if self.config.ExchangeName != "" {
+		if err := self.channel.QueueBind(queue.Name, name, self.config.ExchangeName, false, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return &RabbitTopic{name: queue.Name, channel: self.channel}, nil
+}
+
+// Publish sends body to the given queue
+func (self *RabbitQueue) Publish(topic string, body []byte) error {
+	return self.channel.Publish(self.config.ExchangeName, topic, false, false, amqp.Publishing{
+		Body: body,
+	})
+}
+
+// Healthy reports whether the underlying AMQP connection is open
+func (self *RabbitQueue) Healthy() bool {
+	return self.conn != nil && !self.conn.IsClosed()
+}
+
+// Close shuts down the channel and connection to RabbitMQ
+func (self *RabbitQueue) Close() error {
+	if self.channel != nil {
+		self.channel.Close()
+	}
+
+	if self.conn != nil {
+		return self.conn.Close()
+	}
+
+	return nil
+}
+
+// RabbitTopic is a single RabbitMQ queue that messages are consumed from.
+// Messages are consumed with manual acknowledgement, so a job that's still
+// running when Finn shuts down can be nacked and requeued rather than lost.
+type RabbitTopic struct {
+	name    string
+	channel *amqp.Channel
+}
+
+// Stream consumes messages off the queue and delivers their bodies, acking
+// each one as soon as it's delivered. Prefer StreamAcks when the caller can
+// control when a message is actually done being processed.
+func (self *RabbitTopic) Stream() (<-chan []byte, error) {
+	acks, err := self.StreamAcks()
+	if err != nil {
+		return nil, err
+	}
+
+	stream := make(chan []byte)
+	go func() {
+		for msg := range acks {
+			stream <- msg.Body
+			if err := msg.Ack(); err != nil {
+				LogError(err)
+			}
+		}
+		close(stream)
+	}()
+
+	return stream, nil
+}
+
+// StreamAcks consumes messages off the queue with manual acknowledgement,
+// pairing each one with the functions that ack or nack it. Nacking requeues
+// the message so another consumer (or this one, after a restart) redelivers it.
+func (self *RabbitTopic) StreamAcks() (<-chan AckableMessage, error) {
+	deliveries, err := self.channel.Consume(self.name, "", false, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	acks := make(chan AckableMessage)
+	go func() {
+		for delivery := range deliveries {
+			delivery := delivery
+			acks <- AckableMessage{
+				Body: delivery.Body,
+				Ack: func() error {
+					return delivery.Ack(false)
+				},
+				Nack: func() error {
+					return delivery.Nack(false, true)
+				},
+			}
+		}
+		close(acks)
+	}()
+
+	return acks, nil
+}
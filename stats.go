@@ -0,0 +1,56 @@
+package finn
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WorkerStats holds the running counters for a single worker's topic. All
+// fields are updated via atomics so they're safe to read from the admin
+// HTTP server while workers are running concurrently.
+type WorkerStats struct {
+	Received  int64
+	Succeeded int64
+	Failed    int64
+	Retried   int64
+	Running   int64
+
+	durationNs int64
+}
+
+// AverageDuration returns the average time a completed run of this worker has taken
+func (self *WorkerStats) AverageDuration() time.Duration {
+	completed := atomic.LoadInt64(&self.Succeeded) + atomic.LoadInt64(&self.Failed)
+	if completed == 0 {
+		return 0
+	}
+
+	return time.Duration(atomic.LoadInt64(&self.durationNs) / completed)
+}
+
+// FailureRate returns the fraction of completed runs that have failed, 0 if none have run yet
+func (self *WorkerStats) FailureRate() float64 {
+	succeeded := atomic.LoadInt64(&self.Succeeded)
+	failed := atomic.LoadInt64(&self.Failed)
+	completed := succeeded + failed
+	if completed == 0 {
+		return 0
+	}
+
+	return float64(failed) / float64(completed)
+}
+
+// statsFor returns the WorkerStats for a topic, creating it if this is the first time it's seen
+func (self *Runner) statsFor(topic string) *WorkerStats {
+	if self.stats == nil {
+		self.stats = make(map[string]*WorkerStats)
+	}
+
+	stats, ok := self.stats[topic]
+	if !ok {
+		stats = &WorkerStats{}
+		self.stats[topic] = stats
+	}
+
+	return stats
+}
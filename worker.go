@@ -0,0 +1,212 @@
+package finn
+
+import (
+	"time"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// GenericWorker is the interface a job must satisfy to be run by Finn
+type GenericWorker interface {
+	// TopicName is the topic this worker listens on
+	TopicName() string
+
+	// Name is a human friendly name for the worker, used in logging
+	Name() string
+
+	// Run does the actual work. It returns an error and whether the job should be retried.
+	Run() (error, bool)
+
+	// RunDelay is how long to wait before running the job
+	RunDelay() time.Duration
+
+	// MaxAttempts is how many times a job will be attempted before being dropped
+	MaxAttempts() int
+
+	// Attempts returns how many times this job has been attempted so far
+	Attempts() int
+
+	// IncreaseAttempts increments the attempt counter
+	IncreaseAttempts()
+
+	// RetryDelaySeconds is the base delay, in seconds, fed into Backoff() to compute the next retry
+	RetryDelaySeconds() int
+
+	// Backoff is the strategy used to space out retries
+	Backoff() BackoffStrategy
+
+	// SetStartStamp sets the time the job should next be run at
+	SetStartStamp(stamp time.Time)
+
+	// NextStartStamp computes the next run time given a delay
+	NextStartStamp(delay time.Duration) time.Time
+
+	// FirstSeen is when this job was first attempted, used for dead-letter metadata
+	FirstSeen() time.Time
+
+	// PanicPolicy controls what happens when Run() panics
+	PanicPolicy() PanicPolicy
+
+	// Pack serializes the worker into its wire format
+	Pack() ([]byte, error)
+
+	// Unpack deserializes a message body into the worker
+	Unpack(body []byte) error
+}
+
+// BaseWorker provides sane defaults for the bookkeeping methods of GenericWorker.
+// Embed it into a worker struct and override TopicName, Name and Run.
+type BaseWorker struct {
+	attempts   int
+	firstSeen  time.Time
+	startStamp time.Time
+
+	// self holds a reference to the outer worker that embeds this BaseWorker.
+	// Without it, Pack/Unpack would only ever see BaseWorker's own fields via
+	// the promoted pointer receiver, silently dropping the embedder's data.
+	// It's populated by AddWorker/Enqueue through the selfSetter interface.
+	self GenericWorker
+}
+
+// selfSetter is implemented by BaseWorker so Finn can hand it a reference to
+// the concrete worker it's embedded in, for default (de)serialization.
+type selfSetter interface {
+	setSelf(worker GenericWorker)
+}
+
+// setSelf records the outer worker that embeds this BaseWorker
+func (self *BaseWorker) setSelf(worker GenericWorker) {
+	self.self = worker
+}
+
+// TopicName is a placeholder, concrete workers must override it
+func (self *BaseWorker) TopicName() string {
+	return ""
+}
+
+// Name is a placeholder, concrete workers must override it
+func (self *BaseWorker) Name() string {
+	return "BaseWorker"
+}
+
+// Run is a placeholder, concrete workers must override it
+func (self *BaseWorker) Run() (error, bool) {
+	return nil, false
+}
+
+// RunDelay returns how long until the start stamp set via SetStartStamp
+// (Retry sets this from Backoff().NextDelay() ahead of every retry), or 0 if
+// it's unset or already due.
+func (self *BaseWorker) RunDelay() time.Duration {
+	if self.startStamp.IsZero() {
+		return 0
+	}
+
+	if delay := time.Until(self.startStamp); delay > 0 {
+		return delay
+	}
+
+	return 0
+}
+
+// MaxAttempts defaults to 3 attempts
+func (self *BaseWorker) MaxAttempts() int {
+	return 3
+}
+
+// Attempts returns how many times this job has been attempted so far
+func (self *BaseWorker) Attempts() int {
+	return self.attempts
+}
+
+// IncreaseAttempts increments the attempt counter
+func (self *BaseWorker) IncreaseAttempts() {
+	if self.attempts == 0 {
+		self.firstSeen = time.Now()
+	}
+
+	self.attempts++
+}
+
+// RetryDelaySeconds defaults to a flat 5 second delay between retries
+func (self *BaseWorker) RetryDelaySeconds() int {
+	return 5
+}
+
+// Backoff defaults to FixedBackoff, keeping RetryDelaySeconds() as a flat delay
+func (self *BaseWorker) Backoff() BackoffStrategy {
+	return FixedBackoff{}
+}
+
+// SetStartStamp records when this job should next be run, consulted by RunDelay()
+func (self *BaseWorker) SetStartStamp(stamp time.Time) {
+	self.startStamp = stamp
+}
+
+// NextStartStamp returns now plus the given delay
+func (self *BaseWorker) NextStartStamp(delay time.Duration) time.Time {
+	return time.Now().Add(delay)
+}
+
+// FirstSeen returns when this job was first attempted
+func (self *BaseWorker) FirstSeen() time.Time {
+	return self.firstSeen
+}
+
+// PanicPolicy controls what happens when a worker's Run() panics.
+type PanicPolicy int
+
+const (
+	// PanicPolicyRetry treats a panic like any other retryable error (the default)
+	PanicPolicyRetry PanicPolicy = iota
+
+	// PanicPolicyFail treats a panic as a permanent, non-retryable failure
+	PanicPolicyFail
+)
+
+// PanicPolicy defaults to PanicPolicyRetry
+func (self *BaseWorker) PanicPolicy() PanicPolicy {
+	return PanicPolicyRetry
+}
+
+// Pack is the default wire format, msgpack. Workers that need a different
+// format (JSON, protobuf, Avro, ...) should override it.
+func (self *BaseWorker) Pack() ([]byte, error) {
+	return msgpack.Marshal(self.target())
+}
+
+// Unpack is the default wire format, msgpack. Workers that need a different
+// format (JSON, protobuf, Avro, ...) should override it.
+func (self *BaseWorker) Unpack(body []byte) error {
+	return msgpack.Unmarshal(body, self.target())
+}
+
+// target returns the outer worker if one was registered via setSelf,
+// otherwise falls back to self (losing any fields the embedder added)
+func (self *BaseWorker) target() interface{} {
+	if self.self != nil {
+		return self.self
+	}
+
+	return self
+}
+
+// RawWorker is a BaseWorker that treats the message body as opaque bytes,
+// skipping (de)serialization entirely. Embed it instead of BaseWorker when
+// the payload isn't structured, or when you want to parse it yourself inside
+// Run() without paying a serialization tax on the way in.
+type RawWorker struct {
+	BaseWorker
+	Body []byte
+}
+
+// Pack returns the raw body as-is
+func (self *RawWorker) Pack() ([]byte, error) {
+	return self.Body, nil
+}
+
+// Unpack stores body as-is, without attempting to deserialize it
+func (self *RawWorker) Unpack(body []byte) error {
+	self.Body = body
+	return nil
+}